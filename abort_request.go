@@ -0,0 +1,119 @@
+package deps
+
+import (
+	"sync"
+	"time"
+)
+
+// AbortRequestsBuffer is the capacity of the channel returned by (*Root).AbortRequests.
+const AbortRequestsBuffer = 64
+
+// AbortRequest describes a request of abort by the dependent, which stopped with error.
+type AbortRequest struct {
+	// Path is the path of the dependent requested abort.
+	Path string
+	// Err is the error given to (*Dependency).Stop or (*Dependency).StopImmediately.
+	Err error
+	// Time is the time when abort is requested.
+	Time time.Time
+}
+
+// abortRequests keeps the requests of abort to deliver them in order.
+// It never blocks the dependents requesting abort.
+type abortRequests struct {
+	m       sync.Mutex
+	head    *AbortRequest  // the first request
+	list    []AbortRequest // requests made before the stream is created
+	stream  chan AbortRequest
+	closed  bool
+	dropped int
+}
+
+func (a *abortRequests) add(req AbortRequest) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if a.head == nil {
+		a.head = &req
+	}
+	if a.closed {
+		a.dropped++
+		return
+	}
+	if a.stream == nil {
+		if len(a.list) < AbortRequestsBuffer {
+			a.list = append(a.list, req)
+		} else {
+			a.dropped++
+		}
+		return
+	}
+	select {
+	case a.stream <- req:
+	default: // buffer is full
+		a.dropped++
+	}
+}
+
+func (a *abortRequests) droppedCount() int {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.dropped
+}
+
+func (a *abortRequests) first() AbortRequest {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if a.head == nil {
+		return AbortRequest{}
+	}
+	return *a.head
+}
+
+// close stops accepting requests and closes the stream.
+func (a *abortRequests) close() {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.closed = true
+	if a.stream != nil {
+		close(a.stream)
+	}
+}
+
+func (a *abortRequests) subscribe() <-chan AbortRequest {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if a.stream == nil {
+		a.stream = make(chan AbortRequest, AbortRequestsBuffer)
+		for _, req := range a.list {
+			a.stream <- req
+		}
+		a.list = nil
+		if a.closed {
+			close(a.stream)
+		}
+	}
+	return a.stream
+}
+
+// AbortRequests returns a channel delivering the requests of abort in order,
+// including the ones after the first request which closes AbortRequested channel.
+// The requests made before the call of AbortRequests are also delivered.
+// The channel is closed when Abort returns, so that the caller can log the failures
+// occurred during shutdown by ranging over it.
+//
+// The channel is buffered with the capacity of AbortRequestsBuffer, and sending to
+// it never blocks the dependents. The requests exceeding the buffer are dropped
+// until they are received, and so are the requests made after Abort returns,
+// e.g. by the dependents which didn't stop before the deadline.
+// The number of the dropped requests is reported by DroppedAbortRequests.
+func (r *Root) AbortRequests() <-chan AbortRequest {
+	return r.requests.subscribe()
+}
+
+// DroppedAbortRequests returns the number of the requests of abort which are not
+// delivered by AbortRequests, because the buffer is full or Abort has returned.
+// Checking it after ranging over AbortRequests tells whether the log of the failures
+// is complete.
+func (r *Root) DroppedAbortRequests() int {
+	return r.requests.droppedCount()
+}
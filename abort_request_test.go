@@ -0,0 +1,90 @@
+package deps_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/daichitakahashi/deps"
+)
+
+func TestRoot_AbortRequests(t *testing.T) {
+	t.Parallel()
+
+	root := deps.New()
+	go func(dep *deps.Dependency) { // Dependent 0 fails first
+		err := errors.New("error 0")
+		defer dep.Stop(&err)
+	}(root.Dependent())
+	for i := 1; i <= 2; i++ {
+		go func(dep *deps.Dependency, i int) { // Dependent 1 and 2 fail during shutdown
+			var err error
+			defer dep.Stop(&err)
+
+			<-dep.Aborted()
+			time.Sleep(time.Millisecond * 10 * time.Duration(i))
+			err = errors.New("error")
+		}(root.Dependent(), i)
+	}
+
+	select {
+	case <-root.AbortRequested():
+	case <-time.After(time.Second):
+		t.Fatal("abort not requested")
+	}
+	requests := root.AbortRequests()
+	if err := root.Abort(context.Background()); err != nil {
+		t.Fatalf("graceful abort failed: %s", err)
+	}
+
+	var paths []string
+	for req := range requests {
+		if req.Err == nil || req.Time.IsZero() {
+			t.Fatalf("unexpected request: %+v", req)
+		}
+		paths = append(paths, req.Path)
+	}
+	if len(paths) != 3 || paths[0] != "0" || paths[1] != "1" || paths[2] != "2" {
+		t.Fatalf("unexpected requests: %v", paths)
+	}
+}
+
+func TestRoot_AbortRequests_NonBlocking(t *testing.T) {
+	t.Parallel()
+
+	const count = deps.AbortRequestsBuffer + 10
+	root := deps.New()
+	requests := root.AbortRequests()
+	for i := 0; i < count; i++ {
+		go func(dep *deps.Dependency) {
+			err := errors.New("error")
+			defer dep.Stop(&err)
+		}(root.Dependent())
+	}
+	<-requests // the consumer stops receiving
+
+	// dependents are not blocked by the stream
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := root.Abort(ctx); err != nil {
+		t.Fatalf("graceful abort failed: %s", err)
+	}
+
+	// requests made after Abort returns are dropped
+	dep := root.Dependent()
+	err := errors.New("late error")
+	dep.Stop(&err)
+
+	received := 1
+	for range requests {
+		received++
+	}
+	if received > deps.AbortRequestsBuffer+1 {
+		t.Fatalf("unexpected number of requests: %d", received)
+	}
+	// every request is either received or counted as dropped
+	if dropped := root.DroppedAbortRequests(); received+dropped != count+1 {
+		t.Fatalf("unexpected number of requests: received %d, dropped %d", received, dropped)
+	}
+}
@@ -0,0 +1,84 @@
+package deps
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BudgetSource reports the real termination budget given by the environment, like
+// terminationGracePeriodSeconds of Kubernetes or stopTimeout of Amazon ECS.
+// If the budget is not available, it returns false.
+type BudgetSource func() (time.Duration, bool)
+
+// WithTerminationBudget derives the deadline of the context given to (*Root).Abort
+// from the budget reported by src, instead of hard-coding the timeout which drifts
+// from the settings of the infrastructure.
+// The deadline is the time when Abort is called plus the budget minus margin, so
+// margin should cover the time between the receipt of the termination signal and
+// the call of Abort, and the cleanup after Abort returns.
+// If the context already has the earlier deadline, it is kept.
+// If src doesn't report the budget, or the budget doesn't exceed margin, the context
+// is used as it is, rather than giving the dependents no grace period.
+func WithTerminationBudget(src BudgetSource, margin time.Duration) Option {
+	return func(r *Root) {
+		r.budget = src
+		r.budgetMargin = margin
+	}
+}
+
+func (r *Root) budgetContext(ctx context.Context, now time.Time) (context.Context, context.CancelFunc) {
+	if r.budget == nil {
+		return ctx, func() {}
+	}
+	budget, ok := r.budget()
+	if !ok || budget <= r.budgetMargin {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, now.Add(budget-r.budgetMargin))
+}
+
+// parseBudget parses the budget in seconds like "30", or in the format of
+// [time.ParseDuration] like "30s".
+func parseBudget(s string) (time.Duration, bool) {
+	s = strings.TrimSpace(s)
+	if sec, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return time.Duration(sec) * time.Second, true
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+// EnvBudget reads the budget from the environment variable.
+// The value is in seconds like "30", or in the format of [time.ParseDuration] like "30s".
+// e.g. the environment variable set to the same value as terminationGracePeriodSeconds
+// in the manifest of Kubernetes.
+func EnvBudget(name string) BudgetSource {
+	return func() (time.Duration, bool) {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return 0, false
+		}
+		return parseBudget(v)
+	}
+}
+
+// FileBudget reads the budget from the file.
+// The content is in the same format as EnvBudget.
+// e.g. the file in a ConfigMap volume, which the user writes the same value as
+// terminationGracePeriodSeconds in the manifest of Kubernetes. Note that the downward
+// API can't expose terminationGracePeriodSeconds.
+func FileBudget(path string) BudgetSource {
+	return func() (time.Duration, bool) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return 0, false
+		}
+		return parseBudget(string(b))
+	}
+}
@@ -0,0 +1,179 @@
+package deps_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daichitakahashi/deps"
+)
+
+func TestWithTerminationBudget(t *testing.T) {
+	t.Parallel()
+
+	t.Run("deadline derived from budget", func(t *testing.T) {
+		t.Parallel()
+
+		budget := func() (time.Duration, bool) {
+			return time.Millisecond * 300, true
+		}
+		root := deps.New(deps.WithTerminationBudget(budget, time.Millisecond*100))
+		go func(dep *deps.Dependency) {
+			defer dep.Stop(nil)
+			<-dep.Aborted()
+			<-time.After(time.Second)
+		}(root.Dependent())
+
+		start := time.Now()
+		err := root.Abort(context.Background())
+		var timeoutErr *deps.StopTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Millisecond*200 || elapsed > time.Millisecond*900 {
+			t.Fatalf("unexpected elapsed time: %s", elapsed)
+		}
+	})
+
+	t.Run("budget not exceeding margin", func(t *testing.T) {
+		t.Parallel()
+
+		for _, b := range []time.Duration{0, time.Second} {
+			budget := func() (time.Duration, bool) {
+				return b, true
+			}
+			root := deps.New(deps.WithTerminationBudget(budget, time.Second))
+			go func(dep *deps.Dependency) {
+				defer dep.Stop(nil)
+				<-dep.Aborted()
+				time.Sleep(time.Millisecond * 100) // grace period is given
+			}(root.Dependent())
+
+			if err := root.Abort(context.Background()); err != nil {
+				t.Fatalf("graceful abort failed (budget: %s): %s", b, err)
+			}
+		}
+	})
+
+	t.Run("not queried after abort", func(t *testing.T) {
+		t.Parallel()
+
+		var queried int32
+		budget := func() (time.Duration, bool) {
+			atomic.AddInt32(&queried, 1)
+			return time.Minute, true
+		}
+		root := deps.New(deps.WithTerminationBudget(budget, 0))
+		if err := root.Abort(context.Background()); err != nil {
+			t.Fatalf("graceful abort failed: %s", err)
+		}
+		if err := root.Abort(context.Background()); !errors.Is(err, deps.ErrAlreadyAborted) {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		if n := atomic.LoadInt32(&queried); n != 1 {
+			t.Fatalf("budget queried %d times", n)
+		}
+	})
+
+	t.Run("queried without blocking dependents", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			querying = make(chan struct{})
+			release  = make(chan struct{})
+		)
+		budget := func() (time.Duration, bool) {
+			close(querying)
+			<-release // slow I/O
+			return time.Minute, true
+		}
+		root := deps.New(deps.WithTerminationBudget(budget, 0))
+		result := make(chan error, 1)
+		go func() {
+			result <- root.Abort(context.Background())
+		}()
+		<-querying
+
+		created := make(chan struct{})
+		go func() {
+			defer close(created)
+			root.Dependent().Stop(nil)
+		}()
+		select {
+		case <-created:
+		case <-time.After(time.Second):
+			t.Fatal("Dependent blocked by the query of the budget")
+		}
+		close(release)
+		if err := <-result; err != nil {
+			t.Fatalf("graceful abort failed: %s", err)
+		}
+	})
+
+	t.Run("earlier deadline is kept", func(t *testing.T) {
+		t.Parallel()
+
+		var deadline time.Time
+		budget := func() (time.Duration, bool) {
+			return time.Minute * 10, true
+		}
+		root := deps.New(deps.WithTerminationBudget(budget, 0))
+		go func(dep *deps.Dependency) {
+			defer dep.Stop(nil)
+			<-dep.Aborted()
+			deadline, _ = dep.AbortContext().Deadline()
+		}(root.Dependent())
+
+		expectedDeadline := time.Now().Add(time.Minute)
+		ctx, cancel := context.WithDeadline(context.Background(), expectedDeadline)
+		defer cancel()
+		if err := root.Abort(ctx); err != nil {
+			t.Fatalf("graceful abort failed: %s", err)
+		}
+		if !deadline.Equal(expectedDeadline) {
+			t.Fatalf("unexpected deadline: want %s, got %s", expectedDeadline, deadline)
+		}
+	})
+}
+
+func TestEnvBudget(t *testing.T) {
+	for v, want := range map[string]time.Duration{
+		"30":    time.Second * 30,
+		"1m30s": time.Second * 90,
+		" 5\n":  time.Second * 5,
+	} {
+		t.Setenv("DEPS_TEST_BUDGET", v)
+		got, ok := deps.EnvBudget("DEPS_TEST_BUDGET")()
+		if !ok || got != want {
+			t.Fatalf("unexpected budget of %q: want %s, got %s (%t)", v, want, got, ok)
+		}
+	}
+
+	if _, ok := deps.EnvBudget("DEPS_TEST_NOT_SET")(); ok {
+		t.Fatal("budget reported from unset variable")
+	}
+	t.Setenv("DEPS_TEST_BUDGET", "invalid")
+	if _, ok := deps.EnvBudget("DEPS_TEST_BUDGET")(); ok {
+		t.Fatal("invalid budget accepted")
+	}
+}
+
+func TestFileBudget(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "budget")
+	if _, ok := deps.FileBudget(path)(); ok {
+		t.Fatal("budget reported from missing file")
+	}
+	if err := os.WriteFile(path, []byte("45\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := deps.FileBudget(path)()
+	if !ok || got != time.Second*45 {
+		t.Fatalf("unexpected budget: %s (%t)", got, ok)
+	}
+}
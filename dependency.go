@@ -1,11 +1,32 @@
 // Package deps helps managing lifecycle of the application's dependencies and shutting down gracefully, with minimalistic API.
+//
+// # Memory model
+//
+// In the terms of [The Go Memory Model], deps guarantees the following:
+//   - The call of (*Root).Abort is synchronized before the close of Aborted channel of
+//     the dependents notified automatically, so the writes before Abort are visible to
+//     them after receiving from Aborted. For the dependents of the parent created with
+//     ManualAbort, the call of ForwardAbort (or Stop, which forwards abort) by the parent
+//     is synchronized before the close of their Aborted channel instead.
+//   - The call of Stop or StopImmediately of a registered dependent is synchronized before
+//     the close of Wait channel of its parent and the return of (*Root).Abort with nil error.
+//   - The call of Stop or StopImmediately of any dependent, including the one not
+//     registered (see Registration), is synchronized before the return of StopBarrier
+//     of its parent with nil error.
+//
+// So that, a dependent can hand off the data structures to its parent at its stop
+// without any other synchronization.
+//
+// [The Go Memory Model]: https://go.dev/ref/mem
 package deps
 
 import (
 	"context"
-	"errors"
-	"fmt"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type (
@@ -13,132 +34,473 @@ type (
 	// Root can send signal of shutdown to all its dependents.
 	Root struct {
 		abortRequested chan struct{}
-		requestAbort   func() // request abort
-		aborted        chan struct{}
-		wg             sync.WaitGroup
+		requestOnce    sync.Once
+		requests       abortRequests
+		aborted        signal
+		g              group
+		late           LateDependentPolicy
+		abortedAt      atomic.Pointer[time.Time]
+		profile        func(p *AbortProfile, err error)
+		trace          bool
+		budget         BudgetSource
+		budgetMargin   time.Duration
 
-		abortCtx context.Context
-		rw       sync.RWMutex
+		rw sync.RWMutex
 	}
 
 	// Dependency is a controller of the worker depends on the parent.
 	// After receiving abort signal from the parent, wait its dependent's stop and
 	// notify the parent of its Stop.
 	Dependency struct {
-		requestAbort func()
-		aborted      <-chan struct{}
-		abortCtx     *context.Context
-		rw           *sync.RWMutex
+		root         *Root
+		path         string
+		registration Registration
+		aborted      *signal
+		forward      *signal // abort signal of its dependents
 
-		m    sync.Mutex
-		wait <-chan struct{}
-		wg   sync.WaitGroup
-		stop func() // notify parent
+		g            group
+		unregistered group  // dependents not waited by Wait, but by StopBarrier
+		stop         func() // notify parent
 	}
+
+	// Option configures Root.
+	Option func(r *Root)
+
+	// DependentOption configures Dependency.
+	DependentOption func(d *Dependency)
+)
+
+// LateDependentPolicy decides how Root treats the dependents created after abort,
+// that is, after (*Root).Abort is called, or after ForwardAbort is called by the parent
+// created with ManualAbort.
+type LateDependentPolicy int
+
+const (
+	// LateDependentPreAborted creates the dependent whose Aborted channel is already closed.
+	// The dependent is waited by its parent as usual, unless the parent has already
+	// finished waiting for its dependents.
+	// This is the default policy.
+	LateDependentPreAborted LateDependentPolicy = iota
+	// LateDependentIgnore creates the dependent like LateDependentPreAborted,
+	// but it is never waited by its parent.
+	LateDependentIgnore
+	// LateDependentReject refuses to create the dependent.
+	// (*Root).TryDependent and (*Dependency).TryDependent return ErrLateDependent,
+	// and (*Root).Dependent and (*Dependency).Dependent panic.
+	LateDependentReject
+)
+
+// Registration describes how the dependent is registered to its parent.
+type Registration int
+
+const (
+	// Registered means the dependent is created before abort and waited by its parent.
+	Registered Registration = iota
+	// RegisteredAborted means the dependent is created after abort (see LateDependentPolicy)
+	// and waited by its parent. Its Aborted channel is already closed.
+	RegisteredAborted
+	// Unregistered means the dependent is created after abort and not waited by its parent.
+	Unregistered
 )
 
+// WithLateDependentPolicy specifies the policy for the dependents created after abort.
+func WithLateDependentPolicy(p LateDependentPolicy) Option {
+	return func(r *Root) {
+		r.late = p
+	}
+}
+
+// ManualAbort disables automatic propagation of abort signal to the dependents of
+// the Dependency. Its dependents are notified of abort only when the worker calls
+// (*Dependency).ForwardAbort, so that the worker can order or phase the shutdown
+// of its dependents by itself.
+// If the worker calls Stop or StopImmediately without ForwardAbort, the abort is
+// forwarded when the Dependency itself is notified of abort.
+func ManualAbort() DependentOption {
+	return func(d *Dependency) {
+		d.forward = newSignal()
+	}
+}
+
 // New creates Root controller.
-func New() *Root {
-	r := make(chan struct{})
-	var once sync.Once
-	request := func() {
-		once.Do(func() {
-			close(r)
-		})
+func New(opts ...Option) *Root {
+	root := &Root{
+		abortRequested: make(chan struct{}),
+		aborted:        signal{ch: make(chan struct{})},
 	}
-	return &Root{
-		abortRequested: r,
-		requestAbort:   request,
-		aborted:        make(chan struct{}),
+	for _, opt := range opts {
+		opt(root)
 	}
+	return root
 }
 
 func (r *Root) Aborted() <-chan struct{} {
-	return r.aborted
+	return r.aborted.ch
 }
 
-func wait(wg *sync.WaitGroup) <-chan struct{} {
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-		wg.Wait()
-	}()
-	return done
+// signal is a channel closed once, whose state can be read without select statement.
+// It carries the context of the abort, which is readable after fired.
+type signal struct {
+	ch        chan struct{}
+	m         sync.Mutex
+	ctx       context.Context
+	followers []*signal // fired along with this signal
+	fired     atomic.Bool
+}
+
+func newSignal() *signal {
+	return &signal{ch: make(chan struct{})}
+}
+
+func (s *signal) fire(ctx context.Context) {
+	s.m.Lock()
+	if s.fired.Load() {
+		s.m.Unlock()
+		return
+	}
+	s.ctx = ctx
+	s.fired.Store(true)
+	close(s.ch)
+	followers := s.followers
+	s.followers = nil
+	s.m.Unlock()
+
+	for _, f := range followers {
+		f.fire(ctx)
+	}
+}
+
+// chain makes f fired along with s, with the same context.
+// If s is already fired, f is fired immediately.
+func (s *signal) chain(f *signal) {
+	s.m.Lock()
+	if !s.fired.Load() {
+		s.followers = append(s.followers, f)
+		s.m.Unlock()
+		return
+	}
+	ctx := s.ctx
+	s.m.Unlock()
+	f.fire(ctx)
+}
+
+// group waits for its members to stop like [sync.WaitGroup].
+// Unlike [sync.WaitGroup], a member can be added concurrently with wait.
+type group struct {
+	m       sync.Mutex
+	seq     int
+	members map[*Dependency]struct{}
+	waiting bool
+	done    chan struct{}
+}
+
+// next returns the sequence number for the new member.
+func (g *group) next() int {
+	g.m.Lock()
+	defer g.m.Unlock()
+	n := g.seq
+	g.seq++
+	return n
+}
+
+// add adds the member. If the wait has completed, the group is reused like
+// [sync.WaitGroup] when reuse is true, and the member is refused otherwise.
+func (g *group) add(d *Dependency, reuse bool) bool {
+	g.m.Lock()
+	defer g.m.Unlock()
+	if g.waiting && len(g.members) == 0 {
+		if !reuse {
+			return false
+		}
+		g.waiting = false
+	}
+	if g.members == nil {
+		g.members = map[*Dependency]struct{}{}
+	}
+	g.members[d] = struct{}{}
+	return true
+}
+
+func (g *group) remove(d *Dependency) {
+	g.m.Lock()
+	defer g.m.Unlock()
+	delete(g.members, d)
+	if g.waiting && len(g.members) == 0 {
+		close(g.done)
+	}
+}
+
+// pending appends the paths of the members still working and their descendants.
+func (g *group) pending(paths []string) []string {
+	g.m.Lock()
+	members := make([]*Dependency, 0, len(g.members))
+	for d := range g.members {
+		members = append(members, d)
+	}
+	g.m.Unlock()
+
+	for _, d := range members {
+		paths = append(paths, d.path)
+		paths = d.g.pending(paths)
+	}
+	return paths
+}
+
+func (g *group) wait() <-chan struct{} {
+	g.m.Lock()
+	defer g.m.Unlock()
+	if !g.waiting {
+		g.waiting = true
+		g.done = make(chan struct{})
+		if len(g.members) == 0 {
+			close(g.done)
+		}
+	}
+	return g.done
 }
 
 func (r *Root) AbortRequested() <-chan struct{} {
 	return r.abortRequested
 }
 
+func (r *Root) requestAbort(path string, err error) {
+	r.requests.add(AbortRequest{
+		Path: path,
+		Err:  err,
+		Time: time.Now(),
+	})
+	r.requestOnce.Do(func() {
+		close(r.abortRequested)
+	})
+}
+
 // Abort fires shutdown of the application.
 // When all dependents stopped successfully, it returns nil.
 // The context given as argument can be accessed via (Dependency).AbortContext.
+// If this root is already aborted, it returns ErrAlreadyAborted.
+// If the context is done before all dependents stopped, it returns *StopTimeoutError.
+// If WithTerminationBudget is specified, the deadline derived from the budget is
+// applied to the context. In this case, the context accessible via AbortContext is
+// the derived one, which is canceled when Abort returns.
 func (r *Root) Abort(ctx context.Context) error {
+	now := time.Now()
+	if r.aborted.fired.Load() {
+		return ErrAlreadyAborted
+	}
+	// query the budget without the lock, which may read a file
+	ctx, cancel := r.budgetContext(ctx, now)
+	defer cancel()
+
+	r.rw.Lock()
 	select {
 	case <-r.Aborted():
-		return errors.New("already aborted")
+		r.rw.Unlock()
+		return ErrAlreadyAborted
 	default:
 	}
-	r.rw.Lock()
-	close(r.aborted)
-	r.abortCtx = ctx
+	// start profiling before the dependents are notified, to capture their first reaction
+	stopProfile := r.startProfile()
+	// store the time first, so that AbortedAt reports it once IsAborted is true
+	r.abortedAt.Store(&now)
+	r.aborted.fire(ctx)
 	r.rw.Unlock()
+	defer r.requests.close()
+	defer stopProfile()
 	select {
 	case <-ctx.Done():
-		return fmt.Errorf("failed to wait all dependents to stop: %w", ctx.Err())
-	case <-wait(&r.wg):
+		pending := r.g.pending(nil)
+		sort.Strings(pending)
+		return &StopTimeoutError{
+			Pending: pending,
+			Elapsed: time.Since(now),
+			Err:     ctx.Err(),
+		}
+	case <-r.g.wait():
 		return nil
 	}
 }
 
-func dependent(wg *sync.WaitGroup, requestAbort func(), aborted <-chan struct{}, abortCtx *context.Context, rw *sync.RWMutex) *Dependency {
-	wg.Add(1)
+// AbortedAt returns the time when Abort is called.
+// If this root is not aborted yet, it returns false.
+// It doesn't block, so that the logs can include how long ago the abort began.
+func (r *Root) AbortedAt() (time.Time, bool) {
+	t := r.abortedAt.Load()
+	if t == nil {
+		return time.Time{}, false
+	}
+	return *t, true
+}
+
+func (r *Root) dependent(parent *Dependency, opts []DependentOption) (*Dependency, error) {
+	r.rw.RLock()
+	defer r.rw.RUnlock()
+
+	var (
+		g       = &r.g
+		aborted = &r.aborted
+	)
+	if parent != nil {
+		g = &parent.g
+		aborted = parent.forward
+	}
+	registration := Registered
+	if aborted.fired.Load() {
+		switch r.late {
+		case LateDependentReject:
+			return nil, ErrLateDependent
+		case LateDependentIgnore:
+			registration = Unregistered
+		default:
+			registration = RegisteredAborted
+		}
+	}
+	path := strconv.Itoa(g.next())
+	if parent != nil {
+		path = parent.path + "/" + path
+	}
+	d := &Dependency{
+		root:    r,
+		path:    path,
+		aborted: aborted,
+		forward: aborted,
+		stop:    func() {},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	// before abort, the dependent is always registered like sync.WaitGroup.Add
+	if registration != Unregistered && !g.add(d, registration == Registered) {
+		registration = Unregistered
+	}
+	d.registration = registration
+	if registration == Unregistered {
+		if parent == nil {
+			return d, nil
+		}
+		// not waited by Wait of the parent, but by its StopBarrier
+		g = &parent.unregistered
+		g.add(d, true)
+	}
 	var once sync.Once
-	return &Dependency{
-		requestAbort: requestAbort,
-		aborted:      aborted,
-		abortCtx:     abortCtx,
-		rw:           rw,
-		stop: func() {
-			once.Do(wg.Done)
-		},
+	d.stop = func() {
+		once.Do(func() {
+			g.remove(d)
+		})
+	}
+	return d, nil
+}
+
+func mustDependent(d *Dependency, err error) *Dependency {
+	if err != nil {
+		panic(err)
 	}
+	return d
 }
 
 // Dependent creates the controller depends on this root.
 // Dependency should be created before the statement creating the goroutine or other event
-// to be waited for. Otherwise, a data race could occur.
-// Root uses [sync.WaitGroup] internally. For detail, see [sync.WaitGroup.Add].
-func (r *Root) Dependent() *Dependency {
-	return dependent(&r.wg, r.requestAbort, r.aborted, &r.abortCtx, &r.rw)
+// to be waited for. Otherwise, the dependent may not be waited by Abort.
+// If this root is already aborted, the dependent is created according to LateDependentPolicy.
+// When the policy is LateDependentReject, it panics.
+func (r *Root) Dependent(opts ...DependentOption) *Dependency {
+	return mustDependent(r.dependent(nil, opts))
+}
+
+// TryDependent creates the controller depends on this root like Dependent.
+// When this root is already aborted and the policy is LateDependentReject, it returns
+// ErrLateDependent.
+func (r *Root) TryDependent(opts ...DependentOption) (*Dependency, error) {
+	return r.dependent(nil, opts)
+}
+
+// Path returns the path of this controller in the tree of dependents, like "0/2".
+// Each element is the sequence number of the dependent created by its parent.
+func (d *Dependency) Path() string {
+	return d.path
+}
+
+// Registration reports how this controller is registered to its parent.
+func (d *Dependency) Registration() Registration {
+	return d.registration
 }
 
 // Aborted returns a channel that's closed when its Root aborted.
+// If the parent is created with ManualAbort, it's closed when the parent forwards abort.
 // After the close of Aborted channel, the worker on behalf of this controller
 // will have to start shutdown process including its dependents.
 func (d *Dependency) Aborted() <-chan struct{} {
-	return d.aborted
+	return d.aborted.ch
+}
+
+// IsAborted reports whether Aborted channel is closed.
+// It is cheaper than receiving from Aborted channel with select statement, so that
+// the hot loop of the worker can poll it.
+func (d *Dependency) IsAborted() bool {
+	return d.aborted.fired.Load()
+}
+
+// ForwardAbort notifies the dependents of this controller of abort, when this
+// controller is created with ManualAbort. Otherwise, it does nothing because
+// the dependents are notified automatically.
+// ctx is returned by AbortContext of the dependents. If ctx is nil, AbortContext of
+// this controller is used, or [context.Background] if this controller is not aborted yet.
+func (d *Dependency) ForwardAbort(ctx context.Context) {
+	if d.forward == d.aborted {
+		return
+	}
+	if ctx == nil {
+		ctx = d.AbortContext()
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	d.forward.fire(ctx)
 }
 
 // AbortContext returns a context given to (*Root).Abort.
+// If the parent is created with ManualAbort, it returns the context given to
+// ForwardAbort of the parent instead.
 // The worker on behalf of this controller can get the deadline of shutdown
 // from the context, if specified.
+// If WithTerminationBudget is specified, it returns the context derived by Abort,
+// which is canceled when Abort returns.
+// Before Aborted channel is closed, it returns nil.
 func (d *Dependency) AbortContext() context.Context {
-	d.rw.RLock()
-	defer d.rw.RUnlock()
-	return *d.abortCtx
+	if !d.aborted.fired.Load() {
+		return nil
+	}
+	return d.aborted.ctx
 }
 
 // Wait returns a channel that's closed when its all dependents stopped.
 // To shutdown gracefully, the worker on behalf of this controller have to
 // wait the stop of its children before starting its shutdown process.
 func (d *Dependency) Wait() <-chan struct{} {
-	d.m.Lock()
-	defer d.m.Unlock()
-	if d.wait == nil {
-		d.wait = wait(&d.wg)
+	return d.g.wait()
+}
+
+// StopBarrier blocks until all dependents of this controller stopped, including the
+// ones not registered (see Registration), which Wait doesn't wait for.
+// If ctx is done before that, it returns *StopTimeoutError.
+// When StopBarrier returns nil, all memory writes by the dependents before their stop
+// are visible to the caller, so that the worker can safely use the data structures
+// handed off from its dependents.
+func (d *Dependency) StopBarrier(ctx context.Context) error {
+	start := time.Now()
+	for _, g := range []*group{&d.g, &d.unregistered} {
+		select {
+		case <-g.wait():
+		case <-ctx.Done():
+			pending := d.unregistered.pending(d.g.pending(nil))
+			sort.Strings(pending)
+			return &StopTimeoutError{
+				Pending: pending,
+				Elapsed: time.Since(start),
+				Err:     ctx.Err(),
+			}
+		}
 	}
-	return d.wait
+	return nil
 }
 
 // Stop marks the worker on behalf of this controller stopped after all dependents
@@ -146,7 +508,11 @@ func (d *Dependency) Wait() <-chan struct{} {
 // If abortOnError indicates error, this requests Root to abort.
 func (d *Dependency) Stop(abortOnError *error) {
 	if abortOnError != nil && *abortOnError != nil {
-		d.requestAbort()
+		d.root.requestAbort(d.path, *abortOnError)
+	}
+	if d.forward != d.aborted {
+		// the dependents of ManualAbort are never notified of abort without forwarding
+		d.aborted.chain(d.forward)
 	}
 	<-d.Wait()
 	d.stop()
@@ -157,15 +523,26 @@ func (d *Dependency) Stop(abortOnError *error) {
 // If abortOnError indicates error, this requests Root to abort.
 func (d *Dependency) StopImmediately(abortOnError *error) {
 	if abortOnError != nil && *abortOnError != nil {
-		d.requestAbort()
+		d.root.requestAbort(d.path, *abortOnError)
+	}
+	if d.forward != d.aborted {
+		d.aborted.chain(d.forward)
 	}
 	d.stop()
 }
 
 // Dependent creates the controller depends on this controller.
 // Dependency should be created before the statement creating the goroutine or other event
-// to be waited for. Otherwise, a data race could occur.
-// Dependency uses [sync.WaitGroup] internally. For detail, see [sync.WaitGroup.Add].
-func (d *Dependency) Dependent() *Dependency {
-	return dependent(&d.wg, d.requestAbort, d.aborted, d.abortCtx, d.rw)
+// to be waited for. Otherwise, the dependent may not be waited by Wait.
+// If the abort has already reached the dependents of this controller, the dependent is
+// created according to LateDependentPolicy. When the policy is LateDependentReject, it panics.
+func (d *Dependency) Dependent(opts ...DependentOption) *Dependency {
+	return mustDependent(d.root.dependent(d, opts))
+}
+
+// TryDependent creates the controller depends on this controller like Dependent.
+// When the abort has already reached the dependents of this controller and the policy is LateDependentReject, it returns
+// ErrLateDependent.
+func (d *Dependency) TryDependent(opts ...DependentOption) (*Dependency, error) {
+	return d.root.dependent(d, opts)
 }
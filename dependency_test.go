@@ -116,6 +116,16 @@ func TestRoot_Abort(t *testing.T) {
 		if !errors.Is(err, context.DeadlineExceeded) {
 			t.Fatalf("got unexpected error: %s", err)
 		}
+		var timeoutErr *deps.StopTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+		if len(timeoutErr.Pending) != 1 || timeoutErr.Pending[0] != "0" {
+			t.Fatalf("unexpected pending dependents: %v", timeoutErr.Pending)
+		}
+		if timeoutErr.Elapsed < time.Millisecond*500 {
+			t.Fatalf("unexpected elapsed time: %s", timeoutErr.Elapsed)
+		}
 	})
 
 	t.Run("Abort after Abort", func(t *testing.T) {
@@ -135,8 +145,8 @@ func TestRoot_Abort(t *testing.T) {
 		if err := root.Abort(context.Background()); err != nil {
 			t.Fatalf("graceful abort failed: %s", err)
 		}
-		if err := root.Abort(context.Background()); err == nil {
-			t.Fatal("unexpected success")
+		if err := root.Abort(context.Background()); !errors.Is(err, deps.ErrAlreadyAborted) {
+			t.Fatalf("got unexpected error: %v", err)
 		}
 	})
 }
@@ -261,3 +271,472 @@ func TestDependency_StopImmediately(t *testing.T) {
 		t.Fatal("Dependent B stopped unexpectedly")
 	}
 }
+
+func TestRoot_LateDependent(t *testing.T) {
+	t.Parallel()
+
+	// abortedRoot returns the root which is aborting, while one dependent is working
+	// until release is closed.
+	abortedRoot := func(t *testing.T, p deps.LateDependentPolicy) (root *deps.Root, release func() error) {
+		t.Helper()
+
+		root = deps.New(deps.WithLateDependentPolicy(p))
+		stop := make(chan struct{})
+		go func(dep *deps.Dependency) {
+			defer dep.Stop(nil)
+			<-stop
+		}(root.Dependent())
+
+		result := make(chan error, 1)
+		go func() {
+			result <- root.Abort(context.Background())
+		}()
+		<-root.Aborted()
+		return root, func() error {
+			close(stop)
+			return <-result
+		}
+	}
+
+	t.Run("pre-aborted", func(t *testing.T) {
+		t.Parallel()
+
+		root, release := abortedRoot(t, deps.LateDependentPreAborted)
+		dep, err := root.TryDependent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := dep.Registration(); r != deps.RegisteredAborted {
+			t.Fatalf("unexpected registration: %d", r)
+		}
+		select {
+		case <-dep.Aborted():
+		default:
+			t.Fatal("Aborted not closed")
+		}
+
+		var stopped atomic.Bool
+		go func() {
+			defer dep.Stop(nil)
+			time.Sleep(time.Millisecond * 100)
+			stopped.Store(true)
+		}()
+		if err := release(); err != nil {
+			t.Fatalf("graceful abort failed: %s", err)
+		}
+		if !stopped.Load() {
+			t.Fatal("late dependent not waited")
+		}
+
+		// after all dependents stopped, the dependent can't be waited anymore
+		if r := root.Dependent().Registration(); r != deps.Unregistered {
+			t.Fatalf("unexpected registration: %d", r)
+		}
+	})
+
+	t.Run("ignore", func(t *testing.T) {
+		t.Parallel()
+
+		root, release := abortedRoot(t, deps.LateDependentIgnore)
+		dep := root.Dependent()
+		if r := dep.Registration(); r != deps.Unregistered {
+			t.Fatalf("unexpected registration: %d", r)
+		}
+		select {
+		case <-dep.Aborted():
+		default:
+			t.Fatal("Aborted not closed")
+		}
+		// never stopped
+		if err := release(); err != nil {
+			t.Fatalf("graceful abort failed: %s", err)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		t.Parallel()
+
+		root, release := abortedRoot(t, deps.LateDependentReject)
+		defer func() {
+			_ = release()
+		}()
+		if _, err := root.TryDependent(); !errors.Is(err, deps.ErrLateDependent) {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("Dependent not panicked")
+				}
+			}()
+			root.Dependent()
+		}()
+	})
+}
+
+func TestDependency_IsAborted(t *testing.T) {
+	t.Parallel()
+
+	root := deps.New()
+	if _, ok := root.AbortedAt(); ok {
+		t.Fatal("root aborted unexpectedly")
+	}
+
+	var polled int32
+	go func(dep *deps.Dependency) {
+		defer dep.Stop(nil)
+		for !dep.IsAborted() {
+			atomic.AddInt32(&polled, 1)
+			time.Sleep(time.Millisecond)
+		}
+		if _, ok := root.AbortedAt(); !ok {
+			t.Error("AbortedAt not reported while IsAborted is true")
+		}
+		select {
+		case <-dep.Aborted():
+		default:
+			t.Error("Aborted not closed")
+		}
+	}(root.Dependent())
+
+	time.Sleep(time.Millisecond * 50)
+	before := time.Now()
+	if err := root.Abort(context.Background()); err != nil {
+		t.Fatalf("graceful abort failed: %s", err)
+	}
+	abortedAt, ok := root.AbortedAt()
+	if !ok {
+		t.Fatal("root not aborted")
+	}
+	if abortedAt.Before(before) || abortedAt.After(time.Now()) {
+		t.Fatalf("unexpected abort time: %s", abortedAt)
+	}
+	if atomic.LoadInt32(&polled) == 0 {
+		t.Fatal("IsAborted is true before abort")
+	}
+}
+
+func TestDependency_Path(t *testing.T) {
+	t.Parallel()
+
+	root := deps.New()
+	var (
+		a  = root.Dependent()
+		b  = root.Dependent()
+		b0 = b.Dependent()
+		b1 = b.Dependent()
+	)
+	for want, dep := range map[string]*deps.Dependency{
+		"0":   a,
+		"1":   b,
+		"1/0": b0,
+		"1/1": b1,
+	} {
+		if got := dep.Path(); got != want {
+			t.Fatalf("unexpected path: want %s, got %s", want, got)
+		}
+	}
+}
+
+func TestDependency_ForwardAbort(t *testing.T) {
+	t.Parallel()
+
+	var (
+		root  = deps.New()
+		m     sync.Mutex
+		order []string
+	)
+	record := func(s string) {
+		m.Lock()
+		defer m.Unlock()
+		order = append(order, s)
+	}
+	created := make(chan struct{})
+	go func(dep *deps.Dependency) {
+		defer dep.Stop(nil)
+
+		// the children are notified of abort only after the parent forwards it
+		first := dep.Dependent()
+		second := dep.Dependent()
+		for _, c := range []struct {
+			dep  *deps.Dependency
+			name string
+		}{{first, "first"}, {second, "second"}} {
+			go func(dep *deps.Dependency, name string) {
+				defer dep.Stop(nil)
+				<-dep.Aborted()
+				record(name)
+			}(c.dep, c.name)
+		}
+		close(created)
+
+		<-dep.Aborted()
+		time.Sleep(time.Millisecond * 100)
+		if first.IsAborted() || second.IsAborted() {
+			t.Error("abort propagated to children automatically")
+		}
+		record("parent")
+
+		dep.ForwardAbort(nil)
+		<-dep.Wait()
+	}(root.Dependent(deps.ManualAbort()))
+	<-created
+
+	// ForwardAbort of the dependency without ManualAbort does nothing
+	dep := root.Dependent()
+	dep.ForwardAbort(nil)
+	if dep.IsAborted() {
+		t.Fatal("aborted by ForwardAbort")
+	}
+	dep.Stop(nil)
+
+	if err := root.Abort(context.Background()); err != nil {
+		t.Fatalf("graceful abort failed: %s", err)
+	}
+	m.Lock()
+	defer m.Unlock()
+	if len(order) != 3 || order[0] != "parent" {
+		t.Fatalf("unexpected order: %v", order)
+	}
+}
+
+// TestDependency_StopBarrier checks the hand-off of the data without synchronization.
+// Run with the race detector.
+func TestDependency_StopBarrier(t *testing.T) {
+	t.Parallel()
+
+	const count = 10
+	var (
+		root   = deps.New()
+		result []int // written by the parent, read after Abort
+	)
+	go func(dep *deps.Dependency) {
+		defer dep.Stop(nil)
+
+		results := make([]int, count) // written by the children, read after StopBarrier
+		for i := 0; i < count; i++ {
+			go func(dep *deps.Dependency, i int) {
+				if i%2 == 0 {
+					defer dep.Stop(nil)
+				} else {
+					defer dep.StopImmediately(nil)
+				}
+				<-dep.Aborted()
+				results[i] = i
+			}(dep.Dependent(), i)
+		}
+
+		<-dep.Aborted()
+		if err := dep.StopBarrier(context.Background()); err != nil {
+			t.Error(err)
+		}
+		for _, n := range results {
+			result = append(result, n*n)
+		}
+	}(root.Dependent())
+
+	if err := root.Abort(context.Background()); err != nil {
+		t.Fatalf("graceful abort failed: %s", err)
+	}
+	if len(result) != count {
+		t.Fatalf("unexpected result: %v", result)
+	}
+	for i, n := range result {
+		if n != i*i {
+			t.Fatalf("unexpected result: %v", result)
+		}
+	}
+}
+
+func TestDependency_DependentAfterWait(t *testing.T) {
+	t.Parallel()
+
+	root := deps.New()
+	dep := root.Dependent()
+	<-dep.Wait() // completes with no dependents
+
+	child := dep.Dependent()
+	if r := child.Registration(); r != deps.Registered {
+		t.Fatalf("unexpected registration: %d", r)
+	}
+	select {
+	case <-dep.Wait():
+		t.Fatal("Wait not blocked by the new dependent")
+	default:
+	}
+	child.Stop(nil)
+	<-dep.Wait()
+	dep.Stop(nil)
+
+	if err := root.Abort(context.Background()); err != nil {
+		t.Fatalf("graceful abort failed: %s", err)
+	}
+}
+
+func TestDependency_ManualAbort(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Stop without ForwardAbort", func(t *testing.T) {
+		t.Parallel()
+
+		root := deps.New()
+		go func(dep *deps.Dependency) {
+			err := errors.New("failed")
+			defer dep.Stop(&err) // forwards abort, instead of waiting forever
+
+			child := dep.Dependent()
+			go func() {
+				defer child.Stop(nil)
+				<-child.Aborted()
+			}()
+		}(root.Dependent(deps.ManualAbort()))
+
+		<-root.AbortRequested()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := root.Abort(ctx); err != nil {
+			t.Fatalf("graceful abort failed: %s", err)
+		}
+	})
+
+	t.Run("StopImmediately without ForwardAbort", func(t *testing.T) {
+		t.Parallel()
+
+		root := deps.New()
+		dep := root.Dependent(deps.ManualAbort())
+		child := dep.Dependent()
+		dep.StopImmediately(nil) // abort is forwarded when dep is notified of abort
+		if child.IsAborted() {
+			t.Fatal("aborted before Root aborts")
+		}
+
+		if err := root.Abort(context.Background()); err != nil {
+			t.Fatalf("graceful abort failed: %s", err)
+		}
+		if !child.IsAborted() || child.AbortContext() == nil {
+			t.Fatal("abort not forwarded")
+		}
+		child.Stop(nil)
+	})
+
+	t.Run("late dependent", func(t *testing.T) {
+		t.Parallel()
+
+		root := deps.New()
+		dep := root.Dependent(deps.ManualAbort())
+		result := make(chan error, 1)
+		go func() {
+			result <- root.Abort(context.Background())
+		}()
+		<-dep.Aborted()
+
+		// abort has not reached the dependents yet
+		child := dep.Dependent()
+		if r := child.Registration(); r != deps.Registered {
+			t.Fatalf("unexpected registration: %d", r)
+		}
+		if child.IsAborted() {
+			t.Fatal("aborted before forwarding")
+		}
+
+		dep.ForwardAbort(nil)
+		late := dep.Dependent()
+		if r := late.Registration(); r != deps.RegisteredAborted {
+			t.Fatalf("unexpected registration: %d", r)
+		}
+		if !late.IsAborted() || !child.IsAborted() {
+			t.Fatal("abort not forwarded")
+		}
+
+		child.Stop(nil)
+		late.Stop(nil)
+		dep.Stop(nil)
+		if err := <-result; err != nil {
+			t.Fatalf("graceful abort failed: %s", err)
+		}
+	})
+}
+
+func TestDependency_StopBarrier_Unregistered(t *testing.T) {
+	t.Parallel()
+
+	var (
+		root   = deps.New(deps.WithLateDependentPolicy(deps.LateDependentIgnore))
+		dep    = root.Dependent()
+		result int
+	)
+	go func() {
+		_ = root.Abort(context.Background())
+	}()
+	<-dep.Aborted()
+
+	child := dep.Dependent()
+	if r := child.Registration(); r != deps.Unregistered {
+		t.Fatalf("unexpected registration: %d", r)
+	}
+	<-dep.Wait() // doesn't wait for the unregistered dependent
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	var timeoutErr *deps.StopTimeoutError
+	if err := dep.StopBarrier(ctx); !errors.As(err, &timeoutErr) {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	if len(timeoutErr.Pending) != 1 || timeoutErr.Pending[0] != child.Path() {
+		t.Fatalf("unexpected pending dependents: %v", timeoutErr.Pending)
+	}
+
+	go func() {
+		defer child.Stop(nil)
+		result = 1
+	}()
+	if err := dep.StopBarrier(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if result != 1 {
+		t.Fatal("write by the unregistered dependent not visible")
+	}
+	dep.Stop(nil)
+}
+
+func TestDependency_ForwardAbort_Early(t *testing.T) {
+	t.Parallel()
+
+	type key struct{}
+	var (
+		root  = deps.New()
+		dep   = root.Dependent(deps.ManualAbort())
+		child = dep.Dependent()
+		ctx   = context.WithValue(context.Background(), key{}, "forwarded")
+	)
+
+	// forward before Root aborts
+	dep.ForwardAbort(ctx)
+	<-child.Aborted()
+	abortCtx := child.AbortContext()
+	if abortCtx == nil {
+		t.Fatal("AbortContext is nil")
+	}
+	if v := abortCtx.Value(key{}); v != "forwarded" {
+		t.Fatalf("unexpected context: %v", v)
+	}
+	if dep.AbortContext() != nil {
+		t.Fatal("AbortContext of the parent is not nil before Root aborts")
+	}
+
+	// nil falls back to context.Background
+	dep2 := root.Dependent(deps.ManualAbort())
+	child2 := dep2.Dependent()
+	dep2.ForwardAbort(nil)
+	if child2.AbortContext() == nil {
+		t.Fatal("AbortContext is nil")
+	}
+
+	child.Stop(nil)
+	child2.Stop(nil)
+	dep.Stop(nil)
+	dep2.Stop(nil)
+	if err := root.Abort(context.Background()); err != nil {
+		t.Fatalf("graceful abort failed: %s", err)
+	}
+}
@@ -0,0 +1,71 @@
+package deps
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAlreadyAborted is returned when Root is already aborted.
+	ErrAlreadyAborted = errors.New("already aborted")
+
+	// ErrLateDependent is returned when the dependent is created after abort and
+	// LateDependentReject is specified.
+	ErrLateDependent = errors.New("dependent created after abort")
+
+	// ErrListenerNotInheritable is returned when the listener can't be duplicated or
+	// passed to the child process, because it doesn't have File method.
+	ErrListenerNotInheritable = errors.New("listener can not be inherited")
+
+	// ErrInvalidListenerName is returned by Upgrade when the name of the listener contains comma.
+	ErrInvalidListenerName = errors.New("invalid listener name")
+
+	// ErrChildExited is the cause of *StartupError returned by Upgrade, when the child
+	// process exited before ready.
+	ErrChildExited = errors.New("child process exited before ready")
+)
+
+// StopTimeoutError is returned by (*Root).Abort and (*Dependency).StopBarrier, when
+// the context is done before all dependents stopped.
+type StopTimeoutError struct {
+	// Pending is the paths of the dependents still working.
+	Pending []string
+	// Elapsed is the duration since the abort began for (*Root).Abort, or since
+	// the call of (*Dependency).StopBarrier.
+	Elapsed time.Duration
+	// Err is the error of the context.
+	Err error
+}
+
+func (e *StopTimeoutError) Error() string {
+	return fmt.Sprintf("failed to wait all dependents to stop (pending: [%s], elapsed: %s): %s",
+		strings.Join(e.Pending, " "), e.Elapsed, e.Err)
+}
+
+func (e *StopTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// StartupError is returned by Handover and Upgrade, when the new Root or the child
+// process doesn't get ready.
+type StartupError struct {
+	// Path is the path of the dependent which requested abort of the new Root, if any.
+	Path string
+	// Elapsed is the duration waited for the startup.
+	Elapsed time.Duration
+	// Err is the cause of the failure, like the error requested abort or the error of the context.
+	Err error
+}
+
+func (e *StartupError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("failed to start (dependent: %s, elapsed: %s): %s", e.Path, e.Elapsed, e.Err)
+	}
+	return fmt.Sprintf("failed to start (elapsed: %s): %s", e.Elapsed, e.Err)
+}
+
+func (e *StartupError) Unwrap() error {
+	return e.Err
+}
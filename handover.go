@@ -0,0 +1,92 @@
+package deps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Handover starts a new Root alongside the running one and aborts the old Root
+// only after the new one reports ready, so that the application can be restarted
+// in-process without downtime.
+// start describes the dependents of the new Root in the same way as main does,
+// and calls ready when it is able to serve (e.g. its listeners are open).
+// start is called in the caller's goroutine and ready may be called from any goroutine.
+// The new Root is created with opts.
+//
+// startCtx bounds the wait for ready, and abortCtx is given to (*Root).Abort of either Root.
+// If the new Root requests abort or startCtx is done before ready is called, the new Root
+// is aborted with abortCtx and the old Root keeps working. In this case, *StartupError
+// is returned, joined with the error of (*Root).Abort of the new Root, if any.
+// Otherwise, the old Root is aborted with abortCtx and the new Root is returned, along with
+// the error of (*Root).Abort of the old Root, if any.
+func Handover(startCtx, abortCtx context.Context, old *Root, start func(next *Root, ready func()), opts ...Option) (*Root, error) {
+	var (
+		next    = New(opts...)
+		readyCh = make(chan struct{})
+		once    sync.Once
+		started = time.Now()
+	)
+	start(next, func() {
+		once.Do(func() {
+			close(readyCh)
+		})
+	})
+
+	var startupErr *StartupError
+	select {
+	case <-readyCh:
+	case <-next.AbortRequested():
+		req := next.requests.first()
+		startupErr = &StartupError{
+			Path:    req.Path,
+			Elapsed: time.Since(started),
+			Err:     req.Err,
+		}
+	case <-startCtx.Done():
+		startupErr = &StartupError{
+			Elapsed: time.Since(started),
+			Err:     startCtx.Err(),
+		}
+	}
+	if startupErr != nil {
+		if err := next.Abort(abortCtx); err != nil {
+			return nil, errors.Join(startupErr, fmt.Errorf("failed to abort new root: %w", err))
+		}
+		return nil, startupErr
+	}
+
+	if err := old.Abort(abortCtx); err != nil {
+		return next, fmt.Errorf("failed to abort old root: %w", err)
+	}
+	return next, nil
+}
+
+// DuplicateListener returns a new listener sharing the same socket with l.
+// Closing either of them does not close the other, so the listener duplicated for
+// the new Root of Handover keeps accepting connections while the old Root shuts
+// down its server with l.
+// l must have File method, like [*net.TCPListener] and [*net.UnixListener].
+// Note that closing [*net.UnixListener] removes the socket file by default.
+// See [net.UnixListener.SetUnlinkOnClose].
+func DuplicateListener(l net.Listener) (net.Listener, error) {
+	f, ok := l.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrListenerNotInheritable, l)
+	}
+	file, err := f.File()
+	if err != nil {
+		return nil, fmt.Errorf("failed to duplicate listener: %w", err)
+	}
+	defer file.Close()
+
+	dup, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to duplicate listener: %w", err)
+	}
+	return dup, nil
+}
@@ -0,0 +1,215 @@
+package deps_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daichitakahashi/deps"
+)
+
+func TestHandover(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			old        = deps.New()
+			oldStopped atomic.Bool
+			newAborted atomic.Bool
+		)
+		go func(dep *deps.Dependency) {
+			defer dep.Stop(nil)
+			<-dep.Aborted()
+			oldStopped.Store(true)
+		}(old.Dependent())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		next, err := deps.Handover(ctx, ctx, old, func(next *deps.Root, ready func()) {
+			go func(dep *deps.Dependency) {
+				defer dep.Stop(nil)
+				if oldStopped.Load() {
+					t.Error("old root aborted before ready")
+				}
+				ready()
+				<-dep.Aborted()
+				newAborted.Store(true)
+			}(next.Dependent())
+		}, deps.WithLateDependentPolicy(deps.LateDependentReject))
+		if err != nil {
+			t.Fatalf("handover failed: %s", err)
+		}
+		if !oldStopped.Load() {
+			t.Fatal("old root not aborted")
+		}
+		if newAborted.Load() {
+			t.Fatal("new root aborted unexpectedly")
+		}
+
+		if err := next.Abort(ctx); err != nil {
+			t.Fatalf("graceful abort failed: %s", err)
+		}
+		if !newAborted.Load() {
+			t.Fatal("new root not aborted")
+		}
+		// options are applied to the new root
+		if _, err := next.TryDependent(); !errors.Is(err, deps.ErrLateDependent) {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+	})
+
+	t.Run("new root requests abort", func(t *testing.T) {
+		t.Parallel()
+
+		old := deps.New()
+		go func(dep *deps.Dependency) {
+			defer dep.Stop(nil)
+			<-dep.Aborted()
+		}(old.Dependent())
+
+		next, err := deps.Handover(context.Background(), context.Background(), old, func(next *deps.Root, ready func()) {
+			go func(dep *deps.Dependency) {
+				err := errors.New("failed to start")
+				defer dep.Stop(&err)
+			}(next.Dependent())
+		})
+		var startupErr *deps.StartupError
+		if !errors.As(err, &startupErr) {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		if startupErr.Path != "0" || startupErr.Err.Error() != "failed to start" {
+			t.Fatalf("unexpected startup error: %s", startupErr)
+		}
+		if next != nil {
+			t.Fatal("new root returned unexpectedly")
+		}
+		select {
+		case <-old.Aborted():
+			t.Fatal("old root aborted unexpectedly")
+		default:
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			old        = deps.New()
+			newStopped atomic.Bool
+		)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+
+		_, err := deps.Handover(ctx, context.Background(), old, func(next *deps.Root, ready func()) {
+			go func(dep *deps.Dependency) {
+				defer dep.Stop(nil)
+				<-dep.Aborted()
+				time.Sleep(time.Millisecond * 100) // outlives ctx
+				newStopped.Store(true)
+			}(next.Dependent())
+		})
+		var startupErr *deps.StartupError
+		if !errors.As(err, &startupErr) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		if !newStopped.Load() {
+			t.Fatal("new root not stopped")
+		}
+		select {
+		case <-old.Aborted():
+			t.Fatal("old root aborted unexpectedly")
+		default:
+		}
+	})
+
+	t.Run("new root fails to stop", func(t *testing.T) {
+		t.Parallel()
+
+		old := deps.New()
+		abortCtx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+
+		_, err := deps.Handover(context.Background(), abortCtx, old, func(next *deps.Root, ready func()) {
+			go func(dep *deps.Dependency) {
+				err := errors.New("failed to start")
+				defer dep.Stop(&err)
+
+				child := dep.Dependent()
+				go func() {
+					defer child.Stop(nil)
+					time.Sleep(time.Second) // ignores abort
+				}()
+			}(next.Dependent())
+		})
+		var (
+			startupErr *deps.StartupError
+			timeoutErr *deps.StopTimeoutError
+		)
+		if !errors.As(err, &startupErr) || !errors.As(err, &timeoutErr) {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+	})
+}
+
+func TestDuplicateListener(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dup, err := deps.DuplicateListener(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dup.Close()
+
+	// the duplicated listener keeps accepting after the original is closed
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := net.Dial("tcp", dup.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("ok"))
+	}()
+
+	conn, err := dup.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "ok" {
+		t.Fatalf("unexpected message: %s", b)
+	}
+}
+
+func TestDuplicateListener_NotInheritable(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	_, err = deps.DuplicateListener(struct{ net.Listener }{l})
+	if !errors.Is(err, deps.ErrListenerNotInheritable) {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+}
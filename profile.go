@@ -0,0 +1,79 @@
+package deps
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+)
+
+// AbortProfile is the profile captured while (*Root).Abort is running.
+type AbortProfile struct {
+	// CPU is the CPU profile in the format of pprof.
+	CPU []byte
+	// Trace is the execution trace, if WithAbortTrace is specified.
+	Trace []byte
+	// Start is the time when the profiling started, just before the dependents are
+	// notified of abort.
+	Start time.Time
+	// End is the time when the profiling stopped, just before Abort returns.
+	End time.Time
+}
+
+// WithAbortProfile captures a CPU profile spanning from the notification of abort by
+// (*Root).Abort to its return, and hands it to handle.
+// This helps to analyze what the application spends its grace period doing.
+// If the profiling can't be started, e.g. another CPU profile is running, handle
+// receives the error.
+// handle is called synchronously after the result of Abort is decided, and before
+// Abort returns. So that a slow handle, e.g. uploading the profile, delays the return
+// of Abort even past the deadline of its context. Hand such work off to another
+// goroutine if it matters.
+func WithAbortProfile(handle func(p *AbortProfile, err error)) Option {
+	return func(r *Root) {
+		r.profile = handle
+	}
+}
+
+// WithAbortTrace captures an execution trace along with the CPU profile.
+// It takes effect only when WithAbortProfile is specified.
+func WithAbortTrace() Option {
+	return func(r *Root) {
+		r.trace = true
+	}
+}
+
+// startProfile starts profiling the abort, and returns the function to stop it.
+func (r *Root) startProfile() (stop func()) {
+	if r.profile == nil {
+		return func() {}
+	}
+
+	var cpu, tr bytes.Buffer
+	start := time.Now()
+	if err := pprof.StartCPUProfile(&cpu); err != nil {
+		r.profile(nil, fmt.Errorf("failed to start CPU profile: %w", err))
+		return func() {}
+	}
+	if r.trace {
+		if err := trace.Start(&tr); err != nil {
+			pprof.StopCPUProfile()
+			r.profile(nil, fmt.Errorf("failed to start trace: %w", err))
+			return func() {}
+		}
+	}
+	return func() {
+		p := &AbortProfile{
+			Start: start,
+		}
+		if r.trace {
+			trace.Stop()
+			p.Trace = tr.Bytes()
+		}
+		pprof.StopCPUProfile()
+		p.CPU = cpu.Bytes()
+		p.End = time.Now()
+		r.profile(p, nil)
+	}
+}
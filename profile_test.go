@@ -0,0 +1,56 @@
+package deps_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/daichitakahashi/deps"
+)
+
+func TestWithAbortProfile(t *testing.T) {
+	var (
+		profile *deps.AbortProfile
+		err     error
+	)
+	root := deps.New(
+		deps.WithAbortProfile(func(p *deps.AbortProfile, e error) {
+			profile, err = p, e
+		}),
+		deps.WithAbortTrace(),
+	)
+	go func(dep *deps.Dependency) {
+		defer dep.Stop(nil)
+		<-dep.Aborted()
+
+		// busy shutdown
+		deadline := time.Now().Add(time.Millisecond * 200)
+		for n := 0; time.Now().Before(deadline); n++ {
+			_ = n * n
+		}
+	}(root.Dependent())
+
+	if err := root.Abort(context.Background()); err != nil {
+		t.Fatalf("graceful abort failed: %s", err)
+	}
+	abortedAt, _ := root.AbortedAt()
+	if err != nil {
+		t.Fatalf("failed to profile: %s", err)
+	}
+	if profile == nil {
+		t.Fatal("profile not handled")
+	}
+	if len(profile.CPU) == 0 {
+		t.Fatal("CPU profile is empty")
+	}
+	if len(profile.Trace) == 0 {
+		t.Fatal("trace is empty")
+	}
+	// profiling starts after Abort is called, not before
+	if profile.Start.Before(abortedAt) {
+		t.Fatalf("unexpected profile start: %s", profile.Start)
+	}
+	if profile.End.Sub(profile.Start) < time.Millisecond*200 {
+		t.Fatalf("unexpected profile duration: %s", profile.End.Sub(profile.Start))
+	}
+}
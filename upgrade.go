@@ -0,0 +1,201 @@
+//go:build unix
+
+package deps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The child process started by Upgrade receives the readiness pipe as fd 3 and
+// the listeners from fd 4, in the order of the names listed in upgradeEnv.
+const (
+	upgradeEnv     = "DEPS_UPGRADE_LISTENERS"
+	upgradeReadyFd = 3
+)
+
+var inherited struct {
+	once      sync.Once
+	listeners map[string]net.Listener
+	ready     *os.File
+	err       error
+}
+
+func inherit() {
+	names, ok := os.LookupEnv(upgradeEnv)
+	if !ok {
+		return
+	}
+	_ = os.Unsetenv(upgradeEnv)
+
+	syscall.CloseOnExec(upgradeReadyFd)
+	inherited.ready = os.NewFile(upgradeReadyFd, "ready")
+	inherited.listeners = map[string]net.Listener{}
+	if names == "" {
+		return
+	}
+	for i, name := range strings.Split(names, ",") {
+		fd := upgradeReadyFd + 1 + i
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			inherited.err = fmt.Errorf("failed to inherit listener %q: %w", name, err)
+			return
+		}
+		inherited.listeners[name] = l
+	}
+}
+
+// InheritedListeners returns the listeners passed from the parent process by Upgrade,
+// keyed by its name.
+// If this process is not started by Upgrade, it returns nil.
+func InheritedListeners() (map[string]net.Listener, error) {
+	inherited.once.Do(inherit)
+	return inherited.listeners, inherited.err
+}
+
+// NotifyReady notifies the parent process that this process is ready to serve
+// with the inherited listeners. The parent starts aborting its Root on receipt.
+// If this process is not started by Upgrade, it does nothing.
+func NotifyReady() error {
+	inherited.once.Do(inherit)
+	if inherited.ready == nil {
+		return nil
+	}
+	defer func() {
+		_ = inherited.ready.Close()
+		inherited.ready = nil
+	}()
+	if _, err := inherited.ready.Write([]byte{1}); err != nil {
+		return fmt.Errorf("failed to notify parent of ready: %w", err)
+	}
+	return nil
+}
+
+// Upgrade re-executes the running binary with the same arguments as a child process
+// passing the listeners, so that the binary can be upgraded gracefully.
+// The child process can get the listeners by InheritedListeners, and have to call
+// NotifyReady after it has started serving.
+//
+// The startup of the child process is modeled as a dependent of root. If root is
+// aborted, the child fails or startCtx is done before the child gets ready, the child is
+// killed and Upgrade returns *StartupError without aborting root.
+// After the child gets ready, root is aborted with abortCtx and the error of (*Root).Abort
+// is returned, if any. As Handover, startCtx bounds only the wait for ready, so that
+// a slow startup of the child doesn't shorten the drain of root.
+// The names of listeners must not contain comma.
+func Upgrade(startCtx, abortCtx context.Context, root *Root, listeners map[string]net.Listener) error {
+	path, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable: %w", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create pipe: %w", err)
+	}
+	defer r.Close()
+
+	names := make([]string, 0, len(listeners))
+	files := []*os.File{w}
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for name, l := range listeners {
+		if strings.Contains(name, ",") {
+			return fmt.Errorf("%w: %q", ErrInvalidListenerName, name)
+		}
+		f, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("%w: %q (%T)", ErrListenerNotInheritable, name, l)
+		}
+		file, err := f.File()
+		if err != nil {
+			return fmt.Errorf("failed to get file of listener %q: %w", name, err)
+		}
+		names = append(names, name)
+		files = append(files, file)
+	}
+
+	env := make([]string, 0, len(os.Environ())+1)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, upgradeEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Env = append(env, upgradeEnv+"="+strings.Join(names, ","))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+
+	started := time.Now()
+	dep, err := root.TryDependent()
+	if err != nil {
+		return fmt.Errorf("failed to start upgrade: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		dep.Stop(nil)
+		return fmt.Errorf("failed to start child process: %w", err)
+	}
+	// close the copies of the parent, so that read from the pipe fails when the child exits
+	for _, f := range files {
+		_ = f.Close()
+	}
+	files = nil
+
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		_ = cmd.Wait()
+	}()
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		if errors.Is(err, io.EOF) {
+			err = ErrChildExited
+		}
+		ready <- err
+	}()
+
+	kill := func() {
+		_ = cmd.Process.Kill()
+		<-exited
+		dep.Stop(nil)
+	}
+	var cause error
+	select {
+	case cause = <-ready:
+	case <-dep.Aborted():
+		cause = ErrAlreadyAborted
+	case <-startCtx.Done():
+		cause = startCtx.Err()
+	}
+	if cause != nil {
+		kill()
+		return &StartupError{
+			Elapsed: time.Since(started),
+			Err:     cause,
+		}
+	}
+	dep.Stop(nil)
+
+	if err := root.Abort(abortCtx); err != nil {
+		return fmt.Errorf("failed to abort root: %w", err)
+	}
+	return nil
+}
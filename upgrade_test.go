@@ -0,0 +1,161 @@
+//go:build unix
+
+package deps_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/daichitakahashi/deps"
+)
+
+func TestMain(m *testing.M) {
+	if _, ok := os.LookupEnv("DEPS_UPGRADE_LISTENERS"); ok {
+		os.Exit(upgradedChild())
+	}
+	os.Exit(m.Run())
+}
+
+// upgradedChild behaves as the child process started by deps.Upgrade in TestUpgrade.
+func upgradedChild() int {
+	if os.Getenv("DEPS_TEST_UPGRADE_FAIL") != "" {
+		return 1
+	}
+	if d, err := time.ParseDuration(os.Getenv("DEPS_TEST_UPGRADE_DELAY")); err == nil {
+		time.Sleep(d) // slow startup
+	}
+	listeners, err := deps.InheritedListeners()
+	if err != nil {
+		return 1
+	}
+	l, ok := listeners["test"]
+	if !ok {
+		return 1
+	}
+	if err := deps.NotifyReady(); err != nil {
+		return 1
+	}
+	_ = l.(*net.TCPListener).SetDeadline(time.Now().Add(time.Second * 5))
+	conn, err := l.Accept()
+	if err != nil {
+		return 1
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("child"))
+	return 0
+}
+
+func TestUpgrade(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := deps.New()
+		go func(dep *deps.Dependency) {
+			defer dep.Stop(nil)
+			<-dep.Aborted()
+			_ = l.Close()
+		}(root.Dependent())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := deps.Upgrade(ctx, ctx, root, map[string]net.Listener{"test": l}); err != nil {
+			t.Fatalf("upgrade failed: %s", err)
+		}
+		select {
+		case <-root.Aborted():
+		default:
+			t.Fatal("root not aborted")
+		}
+
+		// the child process accepts connections with the inherited listener
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		b, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "child" {
+			t.Fatalf("unexpected message: %s", b)
+		}
+	})
+
+	t.Run("slow startup", func(t *testing.T) {
+		t.Setenv("DEPS_TEST_UPGRADE_DELAY", "400ms")
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := deps.New()
+		go func(dep *deps.Dependency) {
+			defer dep.Stop(nil)
+			<-dep.Aborted()
+			time.Sleep(time.Millisecond * 300) // drain
+			_ = l.Close()
+		}(root.Dependent())
+
+		// startup takes most of startCtx, but doesn't shorten the drain
+		startCtx, cancel := context.WithTimeout(context.Background(), time.Millisecond*600)
+		defer cancel()
+		if err := deps.Upgrade(startCtx, context.Background(), root, map[string]net.Listener{"test": l}); err != nil {
+			t.Fatalf("upgrade failed: %s", err)
+		}
+
+		// let the child process exit
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.Close()
+	})
+
+	t.Run("child exits before ready", func(t *testing.T) {
+		t.Setenv("DEPS_TEST_UPGRADE_FAIL", "1")
+
+		root := deps.New()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		var startupErr *deps.StartupError
+		if err := deps.Upgrade(ctx, ctx, root, nil); !errors.As(err, &startupErr) || !errors.Is(err, deps.ErrChildExited) {
+			t.Fatalf("got unexpected error: %v", err)
+		}
+		select {
+		case <-root.Aborted():
+			t.Fatal("root aborted unexpectedly")
+		default:
+		}
+		if err := root.Abort(ctx); err != nil {
+			t.Fatalf("graceful abort failed: %s", err)
+		}
+	})
+}
+
+func TestUpgrade_InvalidListener(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	root := deps.New()
+	err = deps.Upgrade(context.Background(), context.Background(), root, map[string]net.Listener{"a,b": l})
+	if !errors.Is(err, deps.ErrInvalidListenerName) {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+	err = deps.Upgrade(context.Background(), context.Background(), root, map[string]net.Listener{"test": struct{ net.Listener }{l}})
+	if !errors.Is(err, deps.ErrListenerNotInheritable) {
+		t.Fatalf("got unexpected error: %v", err)
+	}
+}